@@ -0,0 +1,351 @@
+package main
+
+import (
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"gsb/progress"
+)
+
+// blobstore.go implements a content-addressed "blob" backup mode, enabled
+// per-game via saveConfig.Mode == "blob". Unlike the default zip mode, it
+// splits each backed-up file into one or more chunks, stores each chunk
+// once keyed by its sha256 hash, and records a small JSON manifest per
+// snapshot listing which chunks make up which file - so repeated backups
+// of a mostly-unchanged save tree don't duplicate unchanged bytes on disk.
+// Blob mode always works directly against rootSaveDir: the chunk/manifest
+// layout needs directory listing to do garbage collection, which SaveStore
+// doesn't expose, so it is local-disk only regardless of -store.
+
+const (
+	// blobChunkSize bounds how large a single chunk can get; files at or
+	// under this size are stored as a single whole-file chunk.
+	blobChunkSize = 4 << 20 // 4MiB
+
+	manifestNameFormat = "20060102_150405.json"
+)
+
+// manifestEntry is one file captured by a snapshot manifest.
+type manifestEntry struct {
+	Path   string   `json:"path"`
+	Mode   uint32   `json:"mode"`
+	Chunks []string `json:"chunks"`
+}
+
+type manifestFile struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+func blobDir(game string) string {
+	return filepath.Join(rootSaveDir, game, "blobs")
+}
+
+func snapshotDir(game string) string {
+	return filepath.Join(rootSaveDir, game, "snapshots")
+}
+
+func blobPath(game, hash string) string {
+	return filepath.Join(blobDir(game), hash[:2], hash)
+}
+
+// putBlob stores b under its sha256 hash, deflate-compressed, and returns
+// the hash. It is a no-op if the blob is already on disk.
+func putBlob(game string, b []byte) (string, error) {
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	path := blobPath(game, hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	fp, err := createFile(path)
+	if os.IsExist(err) {
+		// Lost a race with another backup storing the same hash - the
+		// blob is already on disk under its content address, which is
+		// all putBlob promises.
+		return hash, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	fw, err := flate.NewWriter(fp, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(b); err != nil {
+		return "", err
+	}
+	return hash, fw.Close()
+}
+
+func readBlob(game, hash string) ([]byte, error) {
+	fp, err := os.Open(blobPath(game, hash))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	fr := flate.NewReader(fp)
+	defer fr.Close()
+	return ioutil.ReadAll(fr)
+}
+
+// listManifests lists game's blob-mode snapshots, newest first - the blob
+// mode equivalent of SaveStore.List.
+func listManifests(game string) ([]SaveEntry, error) {
+	files, err := ioutil.ReadDir(snapshotDir(game))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	saves := make([]SaveEntry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fname := file.Name()
+		if _, err := time.ParseInLocation(manifestNameFormat, fname, time.UTC); err != nil {
+			continue
+		}
+		saves = append(saves, SaveEntry{Name: fname, Size: file.Size(), ModTime: file.ModTime()})
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].Name > saves[j].Name })
+	return saves, nil
+}
+
+// backupToManifest walks src the same way zipIntoFp does, but stores each
+// file as content-addressed chunks plus a manifest instead of a zip entry.
+func backupToManifest(game, src, tgtName string, op *progress.Operation) error {
+	log.Printf("~ %s -> %s/snapshots/%s (blob mode)", src, game, tgtName)
+	base := filepath.Dir(src)
+	var entries []manifestEntry
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		log.Printf("> %s", path)
+		fp, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		var chunks []string
+		buf := make([]byte, blobChunkSize)
+		for {
+			n, err := io.ReadFull(fp, buf)
+			if n > 0 {
+				hash, putErr := putBlob(game, buf[:n])
+				if putErr != nil {
+					return putErr
+				}
+				chunks = append(chunks, hash)
+				op.AddBytes(int64(n))
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		op.AddFile()
+		entries = append(entries, manifestEntry{Path: rel, Mode: uint32(info.Mode()), Chunks: chunks})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := jsoniter.Marshal(manifestFile{Entries: entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(snapshotDir(game), 0755); err != nil {
+		return err
+	}
+	fp, err := createFile(filepath.Join(snapshotDir(game), tgtName))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write(b)
+	return err
+}
+
+// recoverFromManifest is the blob-mode sibling of recoverFromZip: it
+// reassembles every file listed in the manifest by concatenating its
+// chunks back together.
+func recoverFromManifest(game, manifestName, tgt string, op *progress.Operation) error {
+	log.Printf("~ %s <- %s/snapshots/%s (blob mode)", tgt, game, manifestName)
+	fp, err := os.Open(filepath.Join(snapshotDir(game), manifestName))
+	if err != nil {
+		return err
+	}
+	var mf manifestFile
+	err = jsoniter.NewDecoder(fp).Decode(&mf)
+	fp.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(tgt); err != nil {
+		log.Printf("unable to remove target: %v", err)
+		return err
+	}
+	base := filepath.Dir(tgt)
+
+	var total int64
+	for _, e := range mf.Entries {
+		for _, h := range e.Chunks {
+			if info, err := os.Stat(blobPath(game, h)); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+	op.SetTotal(total)
+
+	for _, e := range mf.Entries {
+		path := filepath.Join(base, e.Path)
+		log.Printf("< %s ...", path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Printf("unable to mkdir: %v", err)
+			return err
+		}
+		if err := func() error {
+			fp, err := os.OpenFile(path, createFileFlags, os.FileMode(e.Mode))
+			if err != nil {
+				log.Printf("unable to create file: %v", err)
+				return err
+			}
+			defer fp.Close()
+			for _, h := range e.Chunks {
+				b, err := readBlob(game, h)
+				if err != nil {
+					return err
+				}
+				if _, err := fp.Write(b); err != nil {
+					return err
+				}
+				// Count the stored (compressed) size, the same measure as total.
+				if info, err := os.Stat(blobPath(game, h)); err == nil {
+					op.AddBytes(info.Size())
+				}
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+		op.AddFile()
+	}
+	return nil
+}
+
+// listSaves dispatches to listManifests or store.List depending on cfg.Mode.
+func listSaves(store SaveStore, cfg *saveConfig, game string) ([]SaveEntry, error) {
+	if cfg.Mode == "blob" {
+		return listManifests(game)
+	}
+	return store.List(game)
+}
+
+// deleteSave dispatches to removing a manifest or store.Delete depending on
+// cfg.Mode. Deleting a blob-mode manifest does not immediately free its
+// chunks - run gcBlobs to reclaim blobs no other manifest still references.
+func deleteSave(store SaveStore, cfg *saveConfig, game, name string) error {
+	if cfg.Mode == "blob" {
+		return os.Remove(filepath.Join(snapshotDir(game), name))
+	}
+	return store.Delete(game, name)
+}
+
+// gcBlobs removes blobs in game's blob store that are not referenced by any
+// remaining snapshot manifest, returning how many it removed.
+func gcBlobs(game string) (int, error) {
+	manifests, err := ioutil.ReadDir(snapshotDir(game))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	live := make(map[string]bool)
+	for _, file := range manifests {
+		if file.IsDir() {
+			continue
+		}
+		if err := func() error {
+			fp, err := os.Open(filepath.Join(snapshotDir(game), file.Name()))
+			if err != nil {
+				return err
+			}
+			defer fp.Close()
+			var mf manifestFile
+			if err := jsoniter.NewDecoder(fp).Decode(&mf); err != nil {
+				return err
+			}
+			for _, e := range mf.Entries {
+				for _, h := range e.Chunks {
+					live[h] = true
+				}
+			}
+			return nil
+		}(); err != nil {
+			return 0, err
+		}
+	}
+
+	prefixes, err := ioutil.ReadDir(blobDir(game))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	removed := 0
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		dir := filepath.Join(blobDir(game), prefix.Name())
+		blobs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return removed, err
+		}
+		for _, b := range blobs {
+			if live[b.Name()] {
+				continue
+			}
+			path := filepath.Join(dir, b.Name())
+			log.Printf("- gc removing blob %s ...", path)
+			if err := os.Remove(path); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"sort"
+)
+
+// diffStatus categorizes how an entry differs between two saves.
+type diffStatus string
+
+const (
+	diffAdded    diffStatus = "added"
+	diffRemoved  diffStatus = "removed"
+	diffModified diffStatus = "modified"
+)
+
+// diffEntry is one file that differs between save A and save B.
+type diffEntry struct {
+	Name   string
+	Status diffStatus
+	SizeA  int64
+	SizeB  int64
+}
+
+// diffSaves compares two zip-mode saves by entry name and CRC32, straight
+// off the zip central directory - no extraction needed - and returns every
+// file that was added, removed or modified. Unchanged files are omitted.
+// If the game has encryption enabled, the caller must hold its cached key.
+func diffSaves(store SaveStore, game, a, b string, cfg *saveConfig, keys *keyCache) ([]diffEntry, error) {
+	open := openZipRandomAccess
+	if enc := cfg.Encryption; enc != nil && enc.Enabled {
+		key, ok := keys.get(game)
+		if !ok {
+			return nil, lockedErr(game)
+		}
+		open = func(store SaveStore, game, name string) (*zip.Reader, func(), error) {
+			return openEncryptedZip(store, game, name, key)
+		}
+	}
+	za, cleanupA, err := open(store, game, a)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupA()
+	zb, cleanupB, err := open(store, game, b)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupB()
+
+	filesA := zipFilesByName(za)
+	filesB := zipFilesByName(zb)
+
+	var entries []diffEntry
+	for name, fa := range filesA {
+		fb, ok := filesB[name]
+		if !ok {
+			entries = append(entries, diffEntry{Name: name, Status: diffRemoved, SizeA: int64(fa.UncompressedSize64)})
+			continue
+		}
+		if fa.CRC32 != fb.CRC32 {
+			entries = append(entries, diffEntry{
+				Name:   name,
+				Status: diffModified,
+				SizeA:  int64(fa.UncompressedSize64),
+				SizeB:  int64(fb.UncompressedSize64),
+			})
+		}
+	}
+	for name, fb := range filesB {
+		if _, ok := filesA[name]; !ok {
+			entries = append(entries, diffEntry{Name: name, Status: diffAdded, SizeB: int64(fb.UncompressedSize64)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func zipFilesByName(zr *zip.Reader) map[string]*zip.File {
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[f.Name] = f
+	}
+	return files
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryption.go adds optional per-game AES-256-GCM encryption of zip-mode
+// archives. A game opts in via saveConfig.Encryption; the passphrase itself
+// is never persisted, only the derived key, and only in memory (see
+// keyCache below). Blob mode is untouched - encrypting individual chunks
+// would need its own design and isn't implemented here.
+
+// encryptionConfig is the per-game encryption setup, persisted in
+// config.json alongside the rest of saveConfig.
+type encryptionConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	KDF     string `json:"kdf,omitempty"` // "scrypt" (default) or "argon2id"
+	Salt    []byte `json:"salt,omitempty"`
+}
+
+const (
+	// encMagic tags a gsb encrypted archive so recoverFromZip can tell it
+	// apart from a plain zip at a glance.
+	encMagic = "GSBENC1\x00"
+	// encChunkSize bounds how much plaintext a single GCM seal covers.
+	encChunkSize = 64 << 10 // 64KiB
+	encSaltSize  = 16
+	encNonceSize = 12
+)
+
+// deriveKey turns a passphrase into a 32-byte AES-256 key using the KDF
+// recorded in the game's encryptionConfig.
+func deriveKey(kdf, passphrase string, salt []byte) ([]byte, error) {
+	switch kdf {
+	case "", "scrypt":
+		return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	case "argon2id":
+		return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32), nil
+	default:
+		return nil, fmt.Errorf("unknown KDF %q", kdf)
+	}
+}
+
+// encryptWriter streams writes through AES-256-GCM in fixed-size chunks,
+// each sealed under its own nonce (a random per-archive prefix plus a
+// big-endian chunk counter), so backupToZip never needs the whole archive
+// in memory. The header (magic, salt length, salt and nonce prefix) is
+// written on first Write, or by Close if nothing was written, so an archive
+// that turns out to be empty still gets one.
+type encryptWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	prefix  [4]byte
+	counter uint64
+	salt    []byte
+	started bool
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, key, salt []byte) (*encryptWriter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	ew := &encryptWriter{w: w, gcm: gcm, salt: salt}
+	if _, err := rand.Read(ew.prefix[:]); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		if err := e.writeHeader(); err != nil {
+			return 0, err
+		}
+		e.started = true
+	}
+	e.buf = append(e.buf, p...)
+	for len(e.buf) >= encChunkSize {
+		if err := e.sealChunk(e.buf[:encChunkSize]); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[encChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close flushes whatever plaintext remains as a final, possibly short,
+// chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if !e.started {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+		e.started = true
+	}
+	if len(e.buf) == 0 {
+		return nil
+	}
+	err := e.sealChunk(e.buf)
+	e.buf = nil
+	return err
+}
+
+func (e *encryptWriter) writeHeader() error {
+	if _, err := e.w.Write([]byte(encMagic)); err != nil {
+		return err
+	}
+	if len(e.salt) > 255 {
+		return errors.New("encryption salt too long")
+	}
+	if _, err := e.w.Write([]byte{byte(len(e.salt))}); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(e.salt); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.prefix[:])
+	return err
+}
+
+func (e *encryptWriter) sealChunk(chunk []byte) error {
+	ct := e.gcm.Seal(nil, e.nonce(), chunk, nil)
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(ct)))
+	if _, err := e.w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(ct)
+	e.counter++
+	return err
+}
+
+func (e *encryptWriter) nonce() []byte {
+	var nonce [encNonceSize]byte
+	copy(nonce[:4], e.prefix[:])
+	binary.BigEndian.PutUint64(nonce[4:], e.counter)
+	return nonce[:]
+}
+
+// decryptReader is the sibling of encryptWriter: it reads the header off an
+// encrypted archive, then opens each length-prefixed chunk in turn.
+type decryptReader struct {
+	r       io.Reader
+	gcm     cipher.AEAD
+	prefix  [4]byte
+	counter uint64
+	buf     []byte
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	var magic [len(encMagic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != encMagic {
+		return nil, errors.New("not a gsb encrypted archive")
+	}
+	var saltLen [1]byte
+	if _, err := io.ReadFull(r, saltLen[:]); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltLen[0])
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, err
+	}
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, gcm: gcm, prefix: prefix}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(d.r, lenBuf[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		ct := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(d.r, ct); err != nil {
+			return 0, err
+		}
+		pt, err := d.gcm.Open(nil, d.nonce(), ct, nil)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt: %w", err)
+		}
+		d.counter++
+		d.buf = pt
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) nonce() []byte {
+	var nonce [encNonceSize]byte
+	copy(nonce[:4], d.prefix[:])
+	binary.BigEndian.PutUint64(nonce[4:], d.counter)
+	return nonce[:]
+}
+
+// openEncryptedZip decrypts game/zipName into a temp file and returns a
+// random-access zip.Reader over the plaintext, plus a cleanup func.
+// Encrypted archives are a sequential GCM chunk stream, not seekable, so -
+// unlike openZipRandomAccess - this always has to stage to disk first.
+func openEncryptedZip(store SaveStore, game, zipName string, key []byte) (*zip.Reader, func(), error) {
+	rc, err := store.Open(game, zipName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	dr, err := newDecryptReader(rc, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stageZipReader(dr)
+}
+
+// keyCache remembers a derived key per game for a limited time after
+// POST /game/:name/unlock, so backups and restores of an encrypted game
+// don't need the passphrase on every request.
+type keyCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	m   map[string]cachedKey
+}
+
+type cachedKey struct {
+	key       []byte
+	expiresAt time.Time
+}
+
+func newKeyCache(ttl time.Duration) *keyCache {
+	return &keyCache{ttl: ttl, m: make(map[string]cachedKey)}
+}
+
+func (c *keyCache) set(game string, key []byte) {
+	c.mu.Lock()
+	c.m[game] = cachedKey{key: key, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+}
+
+func (c *keyCache) get(game string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ck, ok := c.m[game]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(ck.expiresAt) {
+		delete(c.m, game)
+		return nil, false
+	}
+	return ck.key, true
+}
+
+func lockedErr(game string) error {
+	return fmt.Errorf("%s is locked - POST /game/%s/unlock with its passphrase first", game, game)
+}
+
+// rotatingSuffix marks the temp name rotateZipKey stages the re-encrypted
+// archive under before it touches the original. SaveStore has no
+// rename/atomic-replace primitive, so this is the closest thing available
+// to a crash-safe "commit": as long as the staged copy's Put is confirmed,
+// the original is never deleted until a full replacement already exists.
+const rotatingSuffix = ".rotating"
+
+// rotateZipKey re-encrypts a single zip-mode archive under newKey/newSalt.
+// The whole archive is buffered in memory, same tradeoff store_webdav.go
+// already makes for Put - this is a one-off maintenance operation, not the
+// hot path.
+func rotateZipKey(store SaveStore, game, name string, oldKey, newKey, newSalt []byte) error {
+	rc, err := store.Open(game, name)
+	if err != nil {
+		return err
+	}
+	dr, err := newDecryptReader(rc, oldKey)
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	var buf bytes.Buffer
+	ew, err := newEncryptWriter(&buf, newKey, newSalt)
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	_, err = io.Copy(ew, dr)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	tmpName := name + rotatingSuffix
+	// Put errors on an existing name (see store_s3.go's Put for why), so a
+	// retry after a rotation that failed partway through - leaving tmpName
+	// behind - needs to clear it first. It's safe to drop unconditionally:
+	// until the Put below is confirmed, tmpName is the only copy of this
+	// rotation attempt's output, never a copy anything else still needs.
+	if err := store.Delete(game, tmpName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Stage the re-encrypted archive under a temp name first. Until this
+	// Put is confirmed, nothing about the original has been touched, so a
+	// failure here (disk full, network blip) leaves the game exactly as it
+	// was.
+	if err := store.Put(game, tmpName, bytes.NewReader(buf.Bytes())); err != nil {
+		return err
+	}
+	if err := store.Delete(game, name); err != nil {
+		return err
+	}
+	// Re-Put under the real name rather than renaming - SaveStore has no
+	// rename primitive. If this fails, the archive isn't lost: its content
+	// survives under tmpName for a retried rotation or manual recovery.
+	if err := store.Put(game, name, bytes.NewReader(buf.Bytes())); err != nil {
+		return err
+	}
+	return store.Delete(game, tmpName)
+}
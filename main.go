@@ -2,6 +2,7 @@ package main
 
 import (
 	"archive/zip"
+	"crypto/rand"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,17 +14,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/json-iterator/go"
 
 	"gsb/asset"
 	"gsb/config"
+	"gsb/progress"
 )
 
 const (
@@ -34,11 +34,17 @@ const (
 var (
 	port        int
 	rootSaveDir string
+	storeKind   string
+	storeConfig string
+	unlockTTL   string
 )
 
 func init() {
 	flag.IntVar(&port, "port", 9000, "listen port")
-	flag.StringVar(&rootSaveDir, "root", config.DefaultRoot, "root for save")
+	flag.StringVar(&rootSaveDir, "root", config.DefaultRoot, "root for save (local store, and blob-mode games under any store)")
+	flag.StringVar(&storeKind, "store", "local", "save backend: local, s3, webdav or sftp")
+	flag.StringVar(&storeConfig, "store-config", "", "path to a JSON file with backend-specific settings (required for s3/webdav/sftp)")
+	flag.StringVar(&unlockTTL, "unlock-ttl", "15m", "how long a derived encryption key stays cached in memory after /unlock")
 	flag.Parse()
 }
 
@@ -59,42 +65,32 @@ func loadTemplate() (*template.Template, error) {
 
 type saveConfig struct {
 	Src string `json:"src"`
+	// Mode selects the backup format: "" / "zip" (default) writes a full
+	// zip archive per backup; "blob" stores content-addressed chunks plus
+	// a small manifest per snapshot, deduplicating unchanged files.
+	Mode      string           `json:"mode,omitempty"`
+	Retention *retentionConfig `json:"retention,omitempty"`
+	Schedule  *scheduleConfig  `json:"schedule,omitempty"`
+	// Meta holds user-supplied annotations, keyed by save name.
+	Meta map[string]*saveMeta `json:"meta,omitempty"`
+	// Encryption, if set and Enabled, encrypts every zip-mode archive with
+	// a key derived from a passphrase; only the derived key is cached, in
+	// memory - see keyCache.
+	Encryption *encryptionConfig `json:"encryption,omitempty"`
 }
 
-func getConfig(name string) (*saveConfig, error) {
-	cfgFilePath := filepath.Join(rootSaveDir, name, cfgFileName)
-	fp, err := os.Open(cfgFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer fp.Close()
-	cfg := new(saveConfig)
-	if err := jsoniter.NewDecoder(fp).Decode(cfg); err != nil {
-		return nil, err
-	}
-	return cfg, nil
+// saveMeta is a short user-supplied annotation attached to a single save via
+// POST /game/:name/meta/:zip, surfaced on game.html next to its timestamp.
+type saveMeta struct {
+	Label string `json:"label,omitempty"`
+	Note  string `json:"note,omitempty"`
 }
 
-func getAllSaves(name string) ([]string, error) {
-	path := filepath.Join(rootSaveDir, name)
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-	saves := make([]string, 0, len(files))
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		fname := file.Name()
-		_, err := time.ParseInLocation(zipNameFormat, fname, time.UTC)
-		if err != nil {
-			continue
-		}
-		saves = append(saves, fname)
-	}
-	sort.Sort(sort.Reverse(sort.StringSlice(saves)))
-	return saves, nil
+// saveView is what game.html iterates over to render each save's row.
+type saveView struct {
+	Name  string
+	Label string
+	Note  string
 }
 
 const (
@@ -105,8 +101,20 @@ func createFile(path string) (*os.File, error) {
 	return os.OpenFile(path, createFileFlags, 0644)
 }
 
-func zipIntoFp(src string, fp *os.File) error {
-	zw := zip.NewWriter(fp)
+// validGameName reports whether name is safe to use as a single path
+// segment under any SaveStore backend's game directory. Every backend
+// (local, s3, webdav, sftp) joins it onto a root with no traversal check
+// of its own, so this has to catch not just "/" and "\\" but also "."/".."
+// - neither contains a separator, but both escape the intended directory.
+func validGameName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\")
+}
+
+func zipIntoFp(src string, w io.Writer, op *progress.Operation) error {
+	zw := zip.NewWriter(w)
 	defer zw.Close()
 
 	root := filepath.Dir(src)
@@ -142,38 +150,150 @@ func zipIntoFp(src string, fp *os.File) error {
 			return err
 		}
 		defer fp.Close()
-		_, err = io.Copy(w, fp)
-		return err
+		if _, err := io.Copy(w, fp); err != nil {
+			return err
+		}
+		op.AddFile()
+		op.AddBytes(info.Size())
+		return nil
 	})
 }
 
-func backupToZip(src, tgt string) error {
-	log.Printf("~ %s -> %s", src, tgt)
-	fp, err := createFile(tgt)
+func backupToZip(store SaveStore, game, src, tgtName string, cfg *saveConfig, op *progress.Operation, keys *keyCache) error {
+	log.Printf("~ %s -> %s/%s", src, game, tgtName)
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		if enc := cfg.Encryption; enc != nil && enc.Enabled {
+			key, ok := keys.get(game)
+			if !ok {
+				pw.CloseWithError(lockedErr(game))
+				return
+			}
+			ew, ewErr := newEncryptWriter(pw, key, enc.Salt)
+			if ewErr != nil {
+				pw.CloseWithError(ewErr)
+				return
+			}
+			err = zipIntoFp(src, ew, op)
+			if closeErr := ew.Close(); err == nil {
+				err = closeErr
+			}
+		} else {
+			err = zipIntoFp(src, pw, op)
+		}
+		pw.CloseWithError(err)
+	}()
+	return store.Put(game, tgtName, pr)
+}
+
+// triggerBackup picks a free timestamp for game and backs it up, retrying a
+// couple of times in case of a name collision. Used both by the manual
+// POST /game/:name endpoint and by the scheduler (with a nil op).
+func triggerBackup(store SaveStore, game string, cfg *saveConfig, op *progress.Operation, keys *keyCache) error {
+	nameFormat := zipNameFormat
+	if cfg.Mode == "blob" {
+		nameFormat = manifestNameFormat
+	}
+	for i := 0; i < 3; i++ {
+		tgtName := time.Now().UTC().Format(nameFormat)
+		saves, err := listSaves(store, cfg, game)
+		if err != nil {
+			return err
+		}
+		taken := false
+		for _, save := range saves {
+			if save.Name == tgtName {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			if cfg.Mode == "blob" {
+				return backupToManifest(game, cfg.Src, tgtName, op)
+			}
+			return backupToZip(store, game, cfg.Src, tgtName, cfg, op, keys)
+		}
+		time.Sleep(time.Second)
+	}
+	return errors.New("failed to find a timestamp")
+}
+
+// openZipRandomAccess gives back a zip.Reader for game/zipName, using the
+// store's native random access when available and falling back to staging
+// the whole archive to a temp file for backends that can only stream.
+func openZipRandomAccess(store SaveStore, game, zipName string) (*zip.Reader, func(), error) {
+	if ras, ok := store.(ReaderAtStore); ok {
+		ra, size, err := ras.OpenReaderAt(game, zipName)
+		if err != nil {
+			return nil, nil, err
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			ra.Close()
+			return nil, nil, err
+		}
+		return zr, func() { ra.Close() }, nil
+	}
+
+	rc, err := store.Open(game, zipName)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer fp.Close()
-	return zipIntoFp(src, fp)
+	defer rc.Close()
+	return stageZipReader(rc)
 }
 
-func recoverFromZip(src, tgt string) error {
-	log.Printf("~ %s <- %s", tgt, src)
-	var tmpPath string
-	_, err := os.Stat(src)
+// stageZipReader copies r (a zip archive that can only be read
+// sequentially) into a temp file so it can be wrapped in a random-access
+// zip.Reader, and returns a cleanup func that removes the temp file.
+func stageZipReader(r io.Reader) (*zip.Reader, func(), error) {
+	tmp, err := ioutil.TempFile("", "gsb-recover-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, r)
 	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return zr, cleanup, nil
+}
+
+func recoverFromZip(store SaveStore, game, zipName, tgt string, cfg *saveConfig, op *progress.Operation, keys *keyCache) error {
+	log.Printf("~ %s <- %s/%s", tgt, game, zipName)
+	var tmpPath string
+	if rc, err := store.Open(game, zipName); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
 		tmpPath = tgt + ".bkup.zip"
-		_, err := os.Stat(tmpPath)
-		if err == nil {
+		if _, err := os.Stat(tmpPath); err == nil {
 			return fmt.Errorf("please remove %s", tmpPath)
+		} else if !os.IsNotExist(err) {
+			return err
 		}
-		if !os.IsNotExist(err) {
+		fp, err := createFile(tmpPath)
+		if err != nil {
 			return err
 		}
-		if err := backupToZip(tgt, tmpPath); err != nil {
+		err = zipIntoFp(tgt, fp, nil)
+		fp.Close()
+		if err != nil {
 			log.Printf("unable to backup for recover: %v", err)
 			return err
 		}
+	} else {
+		rc.Close()
 	}
 	return func() (err error) {
 		defer func() {
@@ -190,13 +310,40 @@ func recoverFromZip(src, tgt string) error {
 			return err
 		}
 		root := filepath.Dir(tgt)
-		fp, err := zip.OpenReader(src)
+		var zr *zip.Reader
+		var cleanup func()
+		// Detect encryption from the archive's own header, so plain archives
+		// written before encryption was enabled still restore.
+		hrc, err := store.Open(game, zipName)
 		if err != nil {
 			log.Printf("unable to open zip: %v", err)
 			return err
 		}
-		defer fp.Close()
-		for _, f := range fp.File {
+		var magic [len(encMagic)]byte
+		_, herr := io.ReadFull(hrc, magic[:])
+		hrc.Close()
+		if herr == nil && string(magic[:]) == encMagic {
+			key, ok := keys.get(game)
+			if !ok {
+				return lockedErr(game)
+			}
+			zr, cleanup, err = openEncryptedZip(store, game, zipName, key)
+		} else {
+			zr, cleanup, err = openZipRandomAccess(store, game, zipName)
+		}
+		if err != nil {
+			log.Printf("unable to open zip: %v", err)
+			return err
+		}
+		defer cleanup()
+		var total int64
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() {
+				total += int64(f.UncompressedSize64)
+			}
+		}
+		op.SetTotal(total)
+		for _, f := range zr.File {
 			path := filepath.Join(root, f.Name)
 			info := f.FileInfo()
 			if info.IsDir() {
@@ -221,8 +368,13 @@ func recoverFromZip(src, tgt string) error {
 					return err
 				}
 				defer fp.Close()
-				_, err = io.Copy(fp, rc)
-				return err
+				n, err := io.Copy(fp, rc)
+				if err != nil {
+					return err
+				}
+				op.AddFile()
+				op.AddBytes(n)
+				return nil
 			}(); err != nil {
 				return err
 			}
@@ -237,21 +389,66 @@ func main() {
 		panic(err)
 	}
 
+	store, err := newStore()
+	if err != nil {
+		panic(err)
+	}
+
+	ttl, err := time.ParseDuration(unlockTTL)
+	if err != nil {
+		panic(err)
+	}
+
 	var deleteLock sync.Mutex
 	var recoverLock sync.Mutex
+	// backupLock serializes every backup (manual or scheduled) against key
+	// rotation, so rotation's listSaves snapshot can't go stale: without
+	// this, a backup landing after that snapshot would be written under
+	// the still-current key/salt but never get rotated, leaving it
+	// permanently undecryptable once rotation commits the new salt.
+	var backupLock sync.Mutex
+	tracker := progress.NewTracker()
+	keys := newKeyCache(ttl)
+
+	// Backstop for operations whose client never polls /progress/:id (or
+	// its stream) through to completion - those handlers Forget on Done
+	// themselves, but this keeps the tracker bounded regardless.
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			tracker.Sweep(10 * time.Minute)
+		}
+	}()
+
+	startScheduler(store, &deleteLock, &backupLock, keys)
 
 	engine := gin.New()
 	engine.SetHTMLTemplate(t)
 	engine.Use(gin.Recovery())
 	/*
-	engine.Use(func(c *gin.Context) {
-		b, err := httputil.DumpRequest(c.Request, true)
-		if err != nil {
-			panic(err)
+		engine.Use(func(c *gin.Context) {
+			b, err := httputil.DumpRequest(c.Request, true)
+			if err != nil {
+				panic(err)
+			}
+			log.Printf(string(b))
+		})
+	*/
+	// gameGroup covers every /game/:name* route. validGameName is enforced
+	// here, once, rather than in each handler - :name ends up in
+	// store.ReadConfig/List/Open/Delete and, for blob mode, blobstore.go's
+	// blobDir/snapshotDir, all of which join it onto a root directory with
+	// no traversal check of their own.
+	gameGroup := engine.Group("/game/:name")
+	gameGroup.Use(func(c *gin.Context) {
+		if !validGameName(c.Param("name")) {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
 		}
-		log.Printf(string(b))
+		c.Next()
 	})
-	*/
+
 	engine.GET("/static/*name", func(c *gin.Context) {
 		name := c.Param("name")
 		file, ok := asset.Assets.Files["/static"+name]
@@ -275,18 +472,11 @@ func main() {
 			return
 		}
 
-		fps, err := ioutil.ReadDir(rootSaveDir)
+		games, err := store.ListGames()
 		if err != nil {
 			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		var games []string
-		for _, fp := range fps {
-			if !fp.IsDir() {
-				continue
-			}
-			games = append(games, fp.Name())
-		}
 		c.HTML(http.StatusOK, "/static/html/index.html", gin.H{
 			"error": p.Error,
 			"games": games,
@@ -298,105 +488,481 @@ func main() {
 			var p struct {
 				Name string `form:"name" binding:"required"`
 				Path string `form:"path" binding:"required"`
+				Mode string `form:"mode"`
 			}
 			if err := c.ShouldBind(&p); err != nil {
 				return err
 			}
-			// target path
-			tgtPath := filepath.Join(rootSaveDir, p.Name)
-			if rootSaveDir != filepath.Dir(tgtPath) {
+			switch p.Mode {
+			case "", "zip", "blob":
+			default:
+				return errors.New("unknown mode " + p.Mode)
+			}
+			if !validGameName(p.Name) {
 				return errors.New("bad name " + p.Name)
 			}
-			if _, err := os.Stat(tgtPath); err == nil || !os.IsNotExist(err) {
+			if _, err := store.ReadConfig(p.Name); err == nil {
+				return errors.New("game " + p.Name + " already exists")
+			} else if !os.IsNotExist(err) {
 				return err
 			}
 			// src path
-			if _, err = os.Stat(p.Path); err != nil {
+			if _, err := os.Stat(p.Path); err != nil {
 				return err
 			}
 
-			// make config
 			cfg := saveConfig{
-				Src: p.Path,
+				Src:  p.Path,
+				Mode: p.Mode,
+			}
+			if err := store.WriteConfig(p.Name, &cfg); err != nil {
+				return err
+			}
+			c.Redirect(http.StatusMovedPermanently, "/game/"+p.Name)
+			return nil
+		}(); err != nil {
+			v := url.Values{}
+			v.Set("error", err.Error())
+			c.Redirect(http.StatusMovedPermanently, "/?"+v.Encode())
+			return
+		}
+	})
+
+	gameGroup.GET("", func(c *gin.Context) {
+		var p struct {
+			Error string `form:"error"`
+		}
+		if err := c.ShouldBindQuery(&p); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		name := c.Param("name")
+		cfg, err := store.ReadConfig(name)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		entries, err := listSaves(store, cfg, name)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		saves := make([]saveView, len(entries))
+		for i, entry := range entries {
+			sv := saveView{Name: entry.Name}
+			if m := cfg.Meta[entry.Name]; m != nil {
+				sv.Label = m.Label
+				sv.Note = m.Note
 			}
-			b, err := jsoniter.Marshal(cfg)
+			saves[i] = sv
+		}
+		nextRun, err := nextScheduledRun(store, name, cfg)
+		if err != nil {
+			log.Printf("unable to compute next run for %s: %v", name, err)
+		}
+		c.HTML(http.StatusOK, "/static/html/game.html", gin.H{
+			"error":     p.Error,
+			"name":      name,
+			"saves":     saves,
+			"retention": cfg.Retention,
+			"schedule":  cfg.Schedule,
+			"nextRun":   nextRun,
+		})
+	})
+
+	gameGroup.POST("", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		op := tracker.Start()
+		v.Set("opID", op.ID())
+		if err := func() error {
+			backupLock.Lock()
+			defer backupLock.Unlock()
+			cfg, err := store.ReadConfig(name)
 			if err != nil {
 				return err
 			}
+			return triggerBackup(store, name, cfg, op, keys)
+		}(); err != nil {
+			op.Finish(err)
+			v.Set("error", err.Error())
+		} else {
+			op.Finish(nil)
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
 
-			// create directory
-			if err := os.Mkdir(tgtPath, 0755); err != nil {
+	gameGroup.GET("/download/:zip", func(c *gin.Context) {
+		name := c.Param("name")
+		zipName := c.Param("zip")
+		saves, err := store.List(name)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		size := int64(-1)
+		for _, save := range saves {
+			if save.Name == zipName {
+				size = save.Size
+				break
+			}
+		}
+		rc, err := store.Open(name, zipName)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		defer rc.Close()
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, zipName))
+		c.DataFromReader(http.StatusOK, size, "application/zip", rc, nil)
+	})
+
+	gameGroup.POST("/upload", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			if _, err := store.ReadConfig(name); err != nil {
 				return err
 			}
-			fp, err := createFile(filepath.Join(tgtPath, cfgFileName))
+			fh, err := c.FormFile("zip")
 			if err != nil {
 				return err
 			}
-			defer fp.Close()
-			if _, err := fp.Write(b); err != nil {
+			tgtName := fh.Filename
+			if _, err := time.ParseInLocation(zipNameFormat, tgtName, time.UTC); err != nil {
+				tgtName = time.Now().UTC().Format(zipNameFormat)
+			}
+			f, err := fh.Open()
+			if err != nil {
 				return err
 			}
-			c.Redirect(http.StatusMovedPermanently, "/game/"+p.Name)
-			return nil
+			defer f.Close()
+			return store.Put(name, tgtName, f)
 		}(); err != nil {
-			v := url.Values{}
 			v.Set("error", err.Error())
-			c.Redirect(http.StatusMovedPermanently, "/?"+v.Encode())
-			return
 		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
 	})
 
-	engine.GET("/game/:name", func(c *gin.Context) {
+	gameGroup.GET("/diff", func(c *gin.Context) {
 		var p struct {
-			Error string `form:"error"`
+			A string `form:"a" binding:"required"`
+			B string `form:"b" binding:"required"`
 		}
 		if err := c.ShouldBindQuery(&p); err != nil {
 			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-
+		// a/b are query values, not routed path segments, so unlike the
+		// upload handler (which falls back to a generated name) a bad one
+		// here must be rejected outright rather than silently replaced -
+		// otherwise they could carry "../"-style traversal straight into
+		// store.Open.
+		if _, err := time.ParseInLocation(zipNameFormat, p.A, time.UTC); err != nil {
+			c.String(http.StatusBadRequest, "bad save name %q", p.A)
+			return
+		}
+		if _, err := time.ParseInLocation(zipNameFormat, p.B, time.UTC); err != nil {
+			c.String(http.StatusBadRequest, "bad save name %q", p.B)
+			return
+		}
 		name := c.Param("name")
-		saves, err := getAllSaves(name)
+		cfg, err := store.ReadConfig(name)
 		if err != nil {
 			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		c.HTML(http.StatusOK, "/static/html/game.html", gin.H{
-			"error": p.Error,
-			"name":  name,
-			"saves": saves,
+		if cfg.Mode == "blob" {
+			c.String(http.StatusBadRequest, "diff is not supported for blob-mode saves")
+			return
+		}
+		entries, err := diffSaves(store, name, p.A, p.B, cfg, keys)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.HTML(http.StatusOK, "/static/html/diff.html", gin.H{
+			"name":    name,
+			"a":       p.A,
+			"b":       p.B,
+			"entries": entries,
 		})
 	})
 
-	engine.POST("/game/:name", func(c *gin.Context) {
+	gameGroup.POST("/meta/:zip", func(c *gin.Context) {
 		name := c.Param("name")
+		zipName := c.Param("zip")
 		v := url.Values{}
 		if err := func() error {
-			cfg, err := getConfig(name)
+			var p struct {
+				Label string `form:"label"`
+				Note  string `form:"note"`
+			}
+			if err := c.ShouldBind(&p); err != nil {
+				return err
+			}
+			cfg, err := store.ReadConfig(name)
 			if err != nil {
 				return err
 			}
-			for i := 0; i < 3; i++ {
-				tgt := filepath.Join(rootSaveDir, name, time.Now().UTC().Format(zipNameFormat))
-				if _, err := os.Stat(tgt); os.IsNotExist(err) {
-					return backupToZip(cfg.Src, tgt)
+			if cfg.Meta == nil {
+				cfg.Meta = make(map[string]*saveMeta)
+			}
+			cfg.Meta[zipName] = &saveMeta{Label: p.Label, Note: p.Note}
+			return store.WriteConfig(name, cfg)
+		}(); err != nil {
+			v.Set("error", err.Error())
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
+
+	gameGroup.POST("/unlock", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			var p struct {
+				Passphrase string `form:"passphrase" binding:"required"`
+				KDF        string `form:"kdf"`
+			}
+			if err := c.ShouldBind(&p); err != nil {
+				return err
+			}
+			cfg, err := store.ReadConfig(name)
+			if err != nil {
+				return err
+			}
+			enc := cfg.Encryption
+			if enc == nil {
+				// First unlock for this game enables encryption: generate
+				// its salt now, the passphrase is never stored.
+				kdf := p.KDF
+				if kdf == "" {
+					kdf = "scrypt"
+				}
+				salt := make([]byte, encSaltSize)
+				if _, err := rand.Read(salt); err != nil {
+					return err
 				}
-				time.Sleep(time.Second)
+				enc = &encryptionConfig{Enabled: true, KDF: kdf, Salt: salt}
+				cfg.Encryption = enc
+				if err := store.WriteConfig(name, cfg); err != nil {
+					return err
+				}
+			}
+			key, err := deriveKey(enc.KDF, p.Passphrase, enc.Salt)
+			if err != nil {
+				return err
 			}
-			return errors.New("failed to find a timestamp")
+			keys.set(name, key)
+			return nil
 		}(); err != nil {
 			v.Set("error", err.Error())
 		}
 		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
 	})
 
-	engine.POST("/game/:name/delall", func(c *gin.Context) {
+	gameGroup.POST("/rotate", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			var p struct {
+				NewPassphrase string `form:"newPassphrase" binding:"required"`
+				KDF           string `form:"kdf"`
+			}
+			if err := c.ShouldBind(&p); err != nil {
+				return err
+			}
+			recoverLock.Lock()
+			defer recoverLock.Unlock()
+			// Also exclude backups (manual and scheduled): without this, a
+			// backup could land under the old key/salt after listSaves
+			// below snapshots the save list, and never get rotated.
+			backupLock.Lock()
+			defer backupLock.Unlock()
+			cfg, err := store.ReadConfig(name)
+			if err != nil {
+				return err
+			}
+			enc := cfg.Encryption
+			if enc == nil || !enc.Enabled {
+				return errors.New("game " + name + " does not have encryption enabled")
+			}
+			oldKey, ok := keys.get(name)
+			if !ok {
+				return lockedErr(name)
+			}
+			kdf := p.KDF
+			if kdf == "" {
+				kdf = enc.KDF
+			}
+			newSalt := make([]byte, encSaltSize)
+			if _, err := rand.Read(newSalt); err != nil {
+				return err
+			}
+			newKey, err := deriveKey(kdf, p.NewPassphrase, newSalt)
+			if err != nil {
+				return err
+			}
+			saves, err := listSaves(store, cfg, name)
+			if err != nil {
+				return err
+			}
+			// Rotating is all-or-nothing: cfg.Encryption only ever names one
+			// salt, so if a save partway through the list fails to rotate,
+			// every save already rotated to newKey/newSalt must be rolled
+			// back to oldKey/the old salt before returning - otherwise the
+			// config and the archives on disk would disagree about which
+			// key decrypts them.
+			rotated := make([]string, 0, len(saves))
+			for _, s := range saves {
+				if err := rotateZipKey(store, name, s.Name, oldKey, newKey, newSalt); err != nil {
+					for _, done := range rotated {
+						if rerr := rotateZipKey(store, name, done, newKey, oldKey, enc.Salt); rerr != nil {
+							log.Printf("rotate: unable to roll back %s/%s to its old key: %v", name, done, rerr)
+						}
+					}
+					return fmt.Errorf("rotating %s: %w", s.Name, err)
+				}
+				rotated = append(rotated, s.Name)
+			}
+			enc.KDF = kdf
+			enc.Salt = newSalt
+			if err := store.WriteConfig(name, cfg); err != nil {
+				return err
+			}
+			keys.set(name, newKey)
+			return nil
+		}(); err != nil {
+			v.Set("error", err.Error())
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
+
+	engine.GET("/progress/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		op, ok := tracker.Get(id)
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		snap := op.Snapshot()
+		if snap.Done {
+			tracker.Forget(id)
+		}
+		c.JSON(http.StatusOK, snap)
+	})
+
+	engine.GET("/progress/:id/stream", func(c *gin.Context) {
+		id := c.Param("id")
+		op, ok := tracker.Get(id)
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		c.Stream(func(io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-ticker.C:
+			}
+			snap := op.Snapshot()
+			c.SSEvent("progress", snap)
+			if snap.Done {
+				tracker.Forget(id)
+			}
+			return !snap.Done
+		})
+	})
+
+	gameGroup.POST("/prune", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			deleteLock.Lock()
+			defer deleteLock.Unlock()
+			return pruneSaves(store, name)
+		}(); err != nil {
+			v.Set("error", err.Error())
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
+
+	gameGroup.POST("/schedule", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			var p struct {
+				ApplyRetention bool   `form:"applyRetention"`
+				ClearRetention bool   `form:"clearRetention"`
+				KeepLast       int    `form:"keepLast"`
+				KeepDaily      int    `form:"keepDaily"`
+				KeepWeekly     int    `form:"keepWeekly"`
+				KeepMonthly    int    `form:"keepMonthly"`
+				MaxTotalBytes  int64  `form:"maxTotalBytes"`
+				ApplySchedule  bool   `form:"applySchedule"`
+				ClearSchedule  bool   `form:"clearSchedule"`
+				Interval       string `form:"interval"`
+				Cron           string `form:"cron"`
+			}
+			if err := c.ShouldBind(&p); err != nil {
+				return err
+			}
+			cfg, err := store.ReadConfig(name)
+			if err != nil {
+				return err
+			}
+			// Retention and schedule are independent settings submitted from
+			// the same form - only touch the one the caller actually asked
+			// to change, so e.g. setting a schedule alone can't clobber an
+			// existing retention policy down to an all-zero (prune-to-one)
+			// config.
+			switch {
+			case p.ClearRetention:
+				cfg.Retention = nil
+			case p.ApplyRetention:
+				cfg.Retention = &retentionConfig{
+					KeepLast:      p.KeepLast,
+					KeepDaily:     p.KeepDaily,
+					KeepWeekly:    p.KeepWeekly,
+					KeepMonthly:   p.KeepMonthly,
+					MaxTotalBytes: p.MaxTotalBytes,
+				}
+			}
+			switch {
+			case p.ClearSchedule:
+				cfg.Schedule = nil
+			case p.ApplySchedule:
+				sched := &scheduleConfig{Cron: p.Cron}
+				if p.Interval != "" {
+					d, err := time.ParseDuration(p.Interval)
+					if err != nil {
+						return err
+					}
+					sched.Interval = duration(d)
+				}
+				cfg.Schedule = sched
+			}
+			return store.WriteConfig(name, cfg)
+		}(); err != nil {
+			v.Set("error", err.Error())
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
+
+	gameGroup.POST("/delall", func(c *gin.Context) {
 		name := c.Param("name")
 		v := url.Values{}
 		if err := func() error {
 			deleteLock.Lock()
 			defer deleteLock.Unlock()
-			saves, err := getAllSaves(name)
+			cfg, err := store.ReadConfig(name)
+			if err != nil {
+				return err
+			}
+			saves, err := listSaves(store, cfg, name)
 			if err != nil {
 				return err
 			}
@@ -405,9 +971,8 @@ func main() {
 			}
 			for idx, save := range saves {
 				if idx > 0 {
-					path := filepath.Join(rootSaveDir, name, save)
-					log.Printf("- removing %s ...", path)
-					if err := os.Remove(path); err != nil {
+					log.Printf("- removing %s/%s ...", name, save.Name)
+					if err := deleteSave(store, cfg, name, save.Name); err != nil {
 						return err
 					}
 				}
@@ -419,47 +984,73 @@ func main() {
 		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
 	})
 
-	gamesaveWrap := func(cb func(name, zipName string, cfg *saveConfig) error) gin.HandlerFunc {
+	gamesaveWrap := func(cb func(name, zipName string, cfg *saveConfig, v url.Values) error) gin.HandlerFunc {
 		return func(c *gin.Context) {
 			name := c.Param("name")
 			zipName := c.Param("zip")
 			v := url.Values{}
 			if err := func() error {
-				cfg, err := getConfig(name)
+				cfg, err := store.ReadConfig(name)
 				if err != nil {
 					return err
 				}
-				return cb(name, zipName, cfg)
+				return cb(name, zipName, cfg, v)
 			}(); err != nil {
 				v.Set("error", err.Error())
 			}
 			c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
 		}
 	}
-	engine.POST("/game/:name/rec/:zip", gamesaveWrap(
-		func(name, zipName string, cfg *saveConfig) error {
+	gameGroup.POST("/rec/:zip", gamesaveWrap(
+		func(name, zipName string, cfg *saveConfig, v url.Values) error {
 			recoverLock.Lock()
 			defer recoverLock.Unlock()
-			return recoverFromZip(filepath.Join(rootSaveDir, name, zipName), cfg.Src)
+			op := tracker.Start()
+			v.Set("opID", op.ID())
+			var err error
+			if cfg.Mode == "blob" {
+				err = recoverFromManifest(name, zipName, cfg.Src, op)
+			} else {
+				err = recoverFromZip(store, name, zipName, cfg.Src, cfg, op, keys)
+			}
+			op.Finish(err)
+			return err
 		}))
-	engine.POST("/game/:name/del/:zip", gamesaveWrap(
-		func(name, zipName string, cfg *saveConfig) error {
+	gameGroup.POST("/del/:zip", gamesaveWrap(
+		func(name, zipName string, cfg *saveConfig, v url.Values) error {
 			deleteLock.Lock()
 			defer deleteLock.Unlock()
-			saves, err := getAllSaves(name)
+			saves, err := listSaves(store, cfg, name)
 			if err != nil {
 				return err
 			}
 			if len(saves) <= 1 {
 				return errors.New("no save to be deleted")
 			}
-			if zipName == saves[0] {
+			if zipName == saves[0].Name {
 				return errors.New("the first save cannot be deleted")
 			}
-			path := filepath.Join(rootSaveDir, name, zipName)
-			log.Printf("- removing %s ...", path)
-			return os.Remove(path)
+			log.Printf("- removing %s/%s ...", name, zipName)
+			return deleteSave(store, cfg, name, zipName)
 		}))
 
+	gameGroup.POST("/gc", func(c *gin.Context) {
+		name := c.Param("name")
+		v := url.Values{}
+		if err := func() error {
+			deleteLock.Lock()
+			defer deleteLock.Unlock()
+			removed, err := gcBlobs(name)
+			if err != nil {
+				return err
+			}
+			log.Printf("gc: removed %d unreferenced blob(s) for %s", removed, name)
+			return nil
+		}(); err != nil {
+			v.Set("error", err.Error())
+		}
+		c.Redirect(http.StatusMovedPermanently, "/game/"+name+"?"+v.Encode())
+	})
+
 	engine.Run("localhost:" + strconv.Itoa(port))
 }
@@ -0,0 +1,171 @@
+// Package progress tracks the in-flight state of long-running backup and
+// restore operations so the web UI can poll or subscribe to a live
+// bytes/files counter instead of waiting on the request that started them.
+package progress
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Snapshot is a point-in-time, JSON-friendly view of an Operation.
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Total     int64     `json:"total,omitempty"`
+	BytesDone int64     `json:"bytesDone"`
+	FilesDone int64     `json:"filesDone"`
+	Done      bool      `json:"done"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Operation tracks the progress of a single backup or restore. All methods
+// are safe to call on a nil *Operation (a no-op), so callers that were not
+// handed one don't need to guard every call site.
+type Operation struct {
+	id string
+
+	mu    sync.Mutex
+	total int64
+	bytes int64
+	files int64
+	done  bool
+	err   error
+	at    time.Time
+}
+
+// ID returns the operation's tracker key.
+func (o *Operation) ID() string {
+	if o == nil {
+		return ""
+	}
+	return o.id
+}
+
+// SetTotal records the expected total byte count, if known up front.
+func (o *Operation) SetTotal(total int64) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.total = total
+	o.mu.Unlock()
+}
+
+// AddBytes adds n to the bytes-processed counter.
+func (o *Operation) AddBytes(n int64) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.bytes += n
+	o.at = time.Now()
+	o.mu.Unlock()
+}
+
+// AddFile increments the files-processed counter.
+func (o *Operation) AddFile() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.files++
+	o.at = time.Now()
+	o.mu.Unlock()
+}
+
+// Finish marks the operation as complete, recording err (nil on success).
+func (o *Operation) Finish(err error) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.done = true
+	o.err = err
+	o.at = time.Now()
+	o.mu.Unlock()
+}
+
+// Snapshot returns the operation's current state.
+func (o *Operation) Snapshot() Snapshot {
+	if o == nil {
+		return Snapshot{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := Snapshot{
+		ID:        o.id,
+		Total:     o.total,
+		BytesDone: o.bytes,
+		FilesDone: o.files,
+		Done:      o.done,
+		UpdatedAt: o.at,
+	}
+	if o.err != nil {
+		s.Error = o.err.Error()
+	}
+	return s
+}
+
+// Tracker is a registry of operations, keyed by the opaque ID handed out by
+// Start.
+type Tracker struct {
+	mu  sync.Mutex
+	ops map[string]*Operation
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{ops: make(map[string]*Operation)}
+}
+
+// Start registers and returns a fresh Operation under a new random ID.
+func (t *Tracker) Start() *Operation {
+	op := &Operation{id: newID(), at: time.Now()}
+	t.mu.Lock()
+	t.ops[op.id] = op
+	t.mu.Unlock()
+	return op
+}
+
+// Get looks up an Operation by ID.
+func (t *Tracker) Get(id string) (*Operation, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	op, ok := t.ops[id]
+	return op, ok
+}
+
+// Forget drops an operation's bookkeeping, e.g. once no client is watching
+// it anymore.
+func (t *Tracker) Forget(id string) {
+	t.mu.Lock()
+	delete(t.ops, id)
+	t.mu.Unlock()
+}
+
+// Sweep drops every finished operation whose last update is older than
+// maxAge. It's a backstop for operations nobody ever polls or streams to
+// completion, so Forget being caller-driven doesn't leave t.ops growing
+// forever on a long-running instance.
+func (t *Tracker) Sweep(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, op := range t.ops {
+		snap := op.Snapshot()
+		if snap.Done && snap.UpdatedAt.Before(cutoff) {
+			delete(t.ops, id)
+		}
+	}
+}
+
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand is not expected to fail
+	}
+	return hex.EncodeToString(b[:])
+}
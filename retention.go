@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// retentionConfig prunes old saves after a backup, grandfather-father-son
+// style: KeepLast/KeepDaily/KeepWeekly/KeepMonthly each keep the newest N
+// saves that fall in a given day/week/month bucket, and MaxTotalBytes caps
+// the combined size of what survives pruning. The newest save is always
+// kept, matching the existing invariant in POST /game/:name/del/:zip.
+type retentionConfig struct {
+	KeepLast      int   `json:"keepLast,omitempty"`
+	KeepDaily     int   `json:"keepDaily,omitempty"`
+	KeepWeekly    int   `json:"keepWeekly,omitempty"`
+	KeepMonthly   int   `json:"keepMonthly,omitempty"`
+	MaxTotalBytes int64 `json:"maxTotalBytes,omitempty"`
+}
+
+// pruneSaves applies game's retention policy, deleting whatever it decides
+// not to keep. It is a no-op if the game has no Retention configured.
+func pruneSaves(store SaveStore, game string) error {
+	cfg, err := store.ReadConfig(game)
+	if err != nil {
+		return err
+	}
+	if cfg.Retention == nil {
+		return nil
+	}
+	saves, err := listSaves(store, cfg, game)
+	if err != nil {
+		return err
+	}
+	if len(saves) == 0 {
+		return nil
+	}
+	nameFormat := zipNameFormat
+	if cfg.Mode == "blob" {
+		nameFormat = manifestNameFormat
+	}
+
+	keep := make(map[string]bool, len(saves))
+	keep[saves[0].Name] = true // newest save, never pruned
+
+	ret := cfg.Retention
+	for i := 0; i < ret.KeepLast && i < len(saves); i++ {
+		keep[saves[i].Name] = true
+	}
+	bucketKeep(saves, keep, nameFormat, ret.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	bucketKeep(saves, keep, nameFormat, ret.KeepWeekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	})
+	bucketKeep(saves, keep, nameFormat, ret.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	if ret.MaxTotalBytes > 0 {
+		var total int64
+		for _, s := range saves {
+			if keep[s.Name] {
+				total += s.Size
+			}
+		}
+		// saves is newest-first; walk it backwards so the oldest kept
+		// saves are the first to be dropped once over budget.
+		for i := len(saves) - 1; i > 0 && total > ret.MaxTotalBytes; i-- {
+			s := saves[i]
+			if !keep[s.Name] {
+				continue
+			}
+			keep[s.Name] = false
+			total -= s.Size
+		}
+	}
+
+	for _, s := range saves {
+		if keep[s.Name] {
+			continue
+		}
+		log.Printf("- pruning %s/%s ...", game, s.Name)
+		if err := deleteSave(store, cfg, game, s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketKeep marks the newest `limit` saves in each bucket (as produced by
+// bucketOf) as kept. saves must be sorted newest-first.
+func bucketKeep(saves []SaveEntry, keep map[string]bool, nameFormat string, limit int, bucketOf func(time.Time) string) {
+	if limit <= 0 {
+		return
+	}
+	counts := make(map[string]int, len(saves))
+	for _, s := range saves {
+		t, err := time.ParseInLocation(nameFormat, s.Name, time.UTC)
+		if err != nil {
+			continue
+		}
+		b := bucketOf(t)
+		if counts[b] < limit {
+			keep[s.Name] = true
+			counts[b]++
+		}
+	}
+}
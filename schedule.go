@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleConfig drives automatic backups for a game. Set either Interval
+// (e.g. "6h") for a fixed period since the last backup, or Cron (standard
+// 5-field cron syntax) for calendar-based scheduling.
+type scheduleConfig struct {
+	Interval duration `json:"interval,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+}
+
+// duration wraps time.Duration so it can be written as "6h"/"30m" in
+// config.json instead of a raw nanosecond count.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return jsoniter.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := jsoniter.Unmarshal(b, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = duration(parsed)
+		return nil
+	}
+	var ns int64
+	if err := jsoniter.Unmarshal(b, &ns); err != nil {
+		return err
+	}
+	*d = duration(ns)
+	return nil
+}
+
+const schedulerInterval = time.Minute
+
+// startScheduler polls, once a minute, every game's Schedule and triggers a
+// backup (followed by retention pruning) for the ones that are due. It
+// returns immediately; the polling loop runs for the lifetime of the
+// process.
+func startScheduler(store SaveStore, pruneLock, backupLock *sync.Mutex, keys *keyCache) {
+	ticker := time.NewTicker(schedulerInterval)
+	go func() {
+		for range ticker.C {
+			runScheduledBackups(store, pruneLock, backupLock, keys)
+		}
+	}()
+}
+
+func runScheduledBackups(store SaveStore, pruneLock, backupLock *sync.Mutex, keys *keyCache) {
+	games, err := store.ListGames()
+	if err != nil {
+		log.Printf("scheduler: unable to list games: %v", err)
+		return
+	}
+	for _, game := range games {
+		cfg, err := store.ReadConfig(game)
+		if err != nil {
+			log.Printf("scheduler: unable to read config for %s: %v", game, err)
+			continue
+		}
+		if cfg.Schedule == nil {
+			continue
+		}
+		next, err := nextScheduledRun(store, game, cfg)
+		if err != nil {
+			log.Printf("scheduler: unable to compute next run for %s: %v", game, err)
+			continue
+		}
+		if next.IsZero() || time.Now().UTC().Before(next) {
+			continue
+		}
+		// Shared with POST /game/:name and /rotate - see backupLock's
+		// comment in main().
+		backupLock.Lock()
+		err = triggerBackup(store, game, cfg, nil, keys)
+		backupLock.Unlock()
+		if err != nil {
+			log.Printf("scheduler: backup failed for %s: %v", game, err)
+			continue
+		}
+		pruneLock.Lock()
+		err = pruneSaves(store, game)
+		pruneLock.Unlock()
+		if err != nil {
+			log.Printf("scheduler: prune failed for %s: %v", game, err)
+		}
+	}
+}
+
+// nextScheduledRun returns when game's next automatic backup is due, or the
+// zero Time if it has no (or an unparseable) schedule.
+func nextScheduledRun(store SaveStore, game string, cfg *saveConfig) (time.Time, error) {
+	sched := cfg.Schedule
+	if sched == nil {
+		return time.Time{}, nil
+	}
+	saves, err := listSaves(store, cfg, game)
+	if err != nil {
+		return time.Time{}, err
+	}
+	nameFormat := zipNameFormat
+	if cfg.Mode == "blob" {
+		nameFormat = manifestNameFormat
+	}
+	last := time.Now().UTC()
+	if len(saves) > 0 {
+		last, err = time.ParseInLocation(nameFormat, saves[0].Name, time.UTC)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	switch {
+	case sched.Cron != "":
+		spec, err := cron.ParseStandard(sched.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return spec.Next(last), nil
+	case sched.Interval > 0:
+		return last.Add(time.Duration(sched.Interval)), nil
+	default:
+		return time.Time{}, nil
+	}
+}
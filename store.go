@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/json-iterator/go"
+)
+
+// SaveEntry describes a single backup archive known to a SaveStore.
+type SaveEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// SaveStore abstracts the persistence of game configs and save archives so
+// that gsb can keep backups somewhere other than rootSaveDir (a NAS, an S3
+// bucket, a WebDAV share, ...). Archive names returned by List are always
+// zipNameFormat timestamps, already used as the sort/identity key elsewhere
+// in the code; Put/Open/Delete also see the transient rotatingSuffix names
+// rotateZipKey stages under, which List leaves out.
+type SaveStore interface {
+	ListGames() ([]string, error)
+	List(game string) ([]SaveEntry, error)
+	Put(game, name string, r io.Reader) error
+	Open(game, name string) (io.ReadCloser, error)
+	Delete(game, name string) error
+	ReadConfig(game string) (*saveConfig, error)
+	WriteConfig(game string, cfg *saveConfig) error
+}
+
+// ReaderAtCloser is satisfied by anything that can be handed to
+// archive/zip.NewReader once its size is known.
+type ReaderAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// ReaderAtStore is implemented by stores that can hand out random-access
+// readers for an archive directly, without gsb staging it to a temp file
+// first. recoverFromZip uses this when available.
+type ReaderAtStore interface {
+	SaveStore
+	OpenReaderAt(game, name string) (ReaderAtCloser, int64, error)
+}
+
+// newStore builds the configured SaveStore from the -store/-store-config
+// flags. "local" (the default) needs no store config file.
+func newStore() (SaveStore, error) {
+	switch storeKind {
+	case "", "local":
+		return newLocalStore(rootSaveDir), nil
+	case "s3":
+		var cfg s3StoreConfig
+		if err := loadStoreConfig(&cfg); err != nil {
+			return nil, err
+		}
+		return newS3Store(cfg)
+	case "webdav":
+		var cfg webdavStoreConfig
+		if err := loadStoreConfig(&cfg); err != nil {
+			return nil, err
+		}
+		return newWebDAVStore(cfg)
+	case "sftp":
+		var cfg sftpStoreConfig
+		if err := loadStoreConfig(&cfg); err != nil {
+			return nil, err
+		}
+		return newSFTPStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown -store backend %q", storeKind)
+	}
+}
+
+func loadStoreConfig(out interface{}) error {
+	if storeConfig == "" {
+		return fmt.Errorf("-store-config is required for the %q backend", storeKind)
+	}
+	fp, err := os.Open(storeConfig)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return jsoniter.NewDecoder(fp).Decode(out)
+}
+
+// localStore is the original rootSaveDir-on-disk behavior, lifted behind
+// SaveStore so it can be swapped out.
+type localStore struct {
+	root string
+}
+
+func newLocalStore(root string) *localStore {
+	return &localStore{root: root}
+}
+
+func (s *localStore) gameDir(game string) string {
+	return filepath.Join(s.root, game)
+}
+
+func (s *localStore) ListGames() ([]string, error) {
+	fps, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	games := make([]string, 0, len(fps))
+	for _, fp := range fps {
+		if !fp.IsDir() {
+			continue
+		}
+		games = append(games, fp.Name())
+	}
+	return games, nil
+}
+
+func (s *localStore) List(game string) ([]SaveEntry, error) {
+	files, err := ioutil.ReadDir(s.gameDir(game))
+	if err != nil {
+		return nil, err
+	}
+	saves := make([]SaveEntry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fname := file.Name()
+		if _, err := time.ParseInLocation(zipNameFormat, fname, time.UTC); err != nil {
+			continue
+		}
+		saves = append(saves, SaveEntry{Name: fname, Size: file.Size(), ModTime: file.ModTime()})
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].Name > saves[j].Name })
+	return saves, nil
+}
+
+func (s *localStore) Put(game, name string, r io.Reader) error {
+	fp, err := createFile(filepath.Join(s.gameDir(game), name))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = io.Copy(fp, r)
+	return err
+}
+
+func (s *localStore) Open(game, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.gameDir(game), name))
+}
+
+func (s *localStore) OpenReaderAt(game, name string) (ReaderAtCloser, int64, error) {
+	fp, err := os.Open(filepath.Join(s.gameDir(game), name))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, 0, err
+	}
+	return fp, info.Size(), nil
+}
+
+func (s *localStore) Delete(game, name string) error {
+	return os.Remove(filepath.Join(s.gameDir(game), name))
+}
+
+func (s *localStore) ReadConfig(game string) (*saveConfig, error) {
+	fp, err := os.Open(filepath.Join(s.gameDir(game), cfgFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	cfg := new(saveConfig)
+	if err := jsoniter.NewDecoder(fp).Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (s *localStore) WriteConfig(game string, cfg *saveConfig) error {
+	dir := s.gameDir(game)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := jsoniter.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, cfgFileName)
+	if _, err := os.Stat(path); err == nil {
+		return ioutil.WriteFile(path, b, 0644)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	fp, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write(b)
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/json-iterator/go"
+)
+
+// s3StoreConfig is read from the file passed via -store-config when
+// -store=s3. Endpoint is only needed for S3-compatible services (MinIO,
+// Backblaze B2, ...); leave it empty for AWS itself.
+type s3StoreConfig struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"`
+	Region          string `json:"region"`
+	Endpoint        string `json:"endpoint"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	ForcePathStyle  bool   `json:"forcePathStyle"`
+}
+
+type s3Store struct {
+	cfg s3StoreConfig
+	cli *s3.Client
+}
+
+func newS3Store(cfg s3StoreConfig) (*s3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 store: bucket is required")
+	}
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(cfg.Region),
+	}
+	if cfg.AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			cfg.AccessKeyID, cfg.SecretAccessKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	cli := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.ForcePathStyle
+	})
+	return &s3Store{cfg: cfg, cli: cli}, nil
+}
+
+func (s *s3Store) key(game, name string) string {
+	if s.cfg.Prefix == "" {
+		return filepath.ToSlash(filepath.Join(game, name))
+	}
+	return filepath.ToSlash(filepath.Join(s.cfg.Prefix, game, name))
+}
+
+func (s *s3Store) ListGames() ([]string, error) {
+	prefix := s.cfg.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	games := map[string]struct{}{}
+	out, err := s.cli.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.cfg.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range out.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), "/")
+		if name != "" {
+			games[name] = struct{}{}
+		}
+	}
+	list := make([]string, 0, len(games))
+	for name := range games {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (s *s3Store) List(game string) ([]SaveEntry, error) {
+	prefix := s.key(game, "") + "/"
+	var saves []SaveEntry
+	out, err := s.cli.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.cfg.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range out.Contents {
+		fname := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+		if _, err := time.ParseInLocation(zipNameFormat, fname, time.UTC); err != nil {
+			continue
+		}
+		saves = append(saves, SaveEntry{
+			Name:    fname,
+			Size:    aws.ToInt64(obj.Size),
+			ModTime: aws.ToTime(obj.LastModified),
+		})
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].Name > saves[j].Name })
+	return saves, nil
+}
+
+// Put matches localStore.Put's O_EXCL semantics: callers (triggerBackup's
+// collision retry, rotateZipKey's stage-then-promote) depend on it refusing
+// to silently clobber an existing key, not just on the local backend.
+// WriteConfig needs the opposite (a config is rewritten on every update),
+// so it goes through putObject directly instead.
+func (s *s3Store) Put(game, name string, r io.Reader) error {
+	key := s.key(game, name)
+	if _, err := s.cli.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	}); err == nil {
+		return os.ErrExist
+	} else {
+		var nf *types.NotFound
+		if !errors.As(err, &nf) {
+			return err
+		}
+	}
+	return s.putObject(key, r)
+}
+
+func (s *s3Store) putObject(key string, r io.Reader) error {
+	// the SDK needs a ReadSeeker to retry/sign the body, so stage small
+	// config blobs in memory and large archives through a temp file.
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := ioutil.TempFile("", "gsb-s3-put-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = tmp
+	}
+	_, err := s.cli.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+func (s *s3Store) Open(game, name string) (io.ReadCloser, error) {
+	out, err := s.cli.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(game, name)),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Delete(game, name string) error {
+	_, err := s.cli.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(game, name)),
+	})
+	return err
+}
+
+func (s *s3Store) ReadConfig(game string) (*saveConfig, error) {
+	rc, err := s.Open(game, cfgFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	cfg := new(saveConfig)
+	if err := jsoniter.NewDecoder(rc).Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (s *s3Store) WriteConfig(game string, cfg *saveConfig) error {
+	b, err := jsoniter.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return s.putObject(s.key(game, cfgFileName), bytes.NewReader(b))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/studio-b12/gowebdav"
+)
+
+// webdavStoreConfig is read from the file passed via -store-config when
+// -store=webdav.
+type webdavStoreConfig struct {
+	URL      string `json:"url"`
+	Root     string `json:"root"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type webdavStore struct {
+	cfg webdavStoreConfig
+	cli *gowebdav.Client
+}
+
+func newWebDAVStore(cfg webdavStoreConfig) (*webdavStore, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webdav store: url is required")
+	}
+	cli := gowebdav.NewClient(cfg.URL, cfg.User, cfg.Password)
+	if err := cli.Connect(); err != nil {
+		return nil, err
+	}
+	return &webdavStore{cfg: cfg, cli: cli}, nil
+}
+
+func (s *webdavStore) gamePath(game string) string {
+	return path.Join(s.cfg.Root, game)
+}
+
+func (s *webdavStore) objPath(game, name string) string {
+	return path.Join(s.gamePath(game), name)
+}
+
+func (s *webdavStore) ListGames() ([]string, error) {
+	infos, err := s.cli.ReadDir(s.cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+	games := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			games = append(games, info.Name())
+		}
+	}
+	sort.Strings(games)
+	return games, nil
+}
+
+func (s *webdavStore) List(game string) ([]SaveEntry, error) {
+	infos, err := s.cli.ReadDir(s.gamePath(game))
+	if err != nil {
+		return nil, err
+	}
+	saves := make([]SaveEntry, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		fname := info.Name()
+		if _, err := time.ParseInLocation(zipNameFormat, fname, time.UTC); err != nil {
+			continue
+		}
+		saves = append(saves, SaveEntry{Name: fname, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].Name > saves[j].Name })
+	return saves, nil
+}
+
+// Put matches localStore.Put's O_EXCL semantics: callers (triggerBackup's
+// collision retry, rotateZipKey's stage-then-promote) depend on it refusing
+// to silently clobber an existing name, not just on the local backend. The
+// existence check and the write aren't atomic together - gowebdav has no
+// conditional-PUT primitive - but it's the same best-effort tradeoff
+// putBlob already makes for a content-addressed write race.
+// WriteConfig needs the opposite (a config is rewritten on every update),
+// so it goes through writeObject directly instead.
+func (s *webdavStore) Put(game, name string, r io.Reader) error {
+	if _, err := s.cli.Stat(s.objPath(game, name)); err == nil {
+		return os.ErrExist
+	}
+	if err := s.cli.MkdirAll(s.gamePath(game), 0755); err != nil {
+		return err
+	}
+	return s.writeObject(s.objPath(game, name), r)
+}
+
+func (s *webdavStore) writeObject(objPath string, r io.Reader) error {
+	// gowebdav needs the body length up front, so buffer it; archives are
+	// modest-sized save zips, not multi-gigabyte assets.
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.cli.Write(objPath, b, 0644)
+}
+
+func (s *webdavStore) Open(game, name string) (io.ReadCloser, error) {
+	// cli.Read buffers the whole body into memory before returning it;
+	// cli.ReadStream hands back the GET's body directly, which is what
+	// download/restore/diff actually want to stream off a remote backend.
+	return s.cli.ReadStream(s.objPath(game, name))
+}
+
+func (s *webdavStore) Delete(game, name string) error {
+	return s.cli.Remove(s.objPath(game, name))
+}
+
+func (s *webdavStore) ReadConfig(game string) (*saveConfig, error) {
+	rc, err := s.Open(game, cfgFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	cfg := new(saveConfig)
+	if err := jsoniter.NewDecoder(rc).Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (s *webdavStore) WriteConfig(game string, cfg *saveConfig) error {
+	if err := s.cli.MkdirAll(s.gamePath(game), 0755); err != nil {
+		return err
+	}
+	b, err := jsoniter.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return s.writeObject(s.objPath(game, cfgFileName), bytes.NewReader(b))
+}